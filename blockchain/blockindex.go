@@ -0,0 +1,528 @@
+// Copyright (c) 2015-2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"container/list"
+	"math/big"
+	"sync"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/wire"
+)
+
+// blockNodeRecord is the on-disk representation of a block node used to
+// persist nodes to, and reload them from, a blockIndexStore.
+type blockNodeRecord struct {
+	header          wire.BlockHeader
+	workSum         *big.Int
+	status          blockStatus
+	receivedOrderID uint32
+}
+
+// defaultBlockIndexCacheSize is the default number of non-essential block
+// nodes (i.e. nodes that are neither on the current best chain nor a known
+// chain tip) that are kept resident in memory before the least-recently-used
+// entries are evicted and reloaded on demand the next time they are needed.
+// Eviction only takes place when a backing store is configured.
+const defaultBlockIndexCacheSize = 100000
+
+// blockIndexStore defines the behavior required of a backing store that
+// persists block nodes so that they can be removed from memory and reloaded
+// later.  This is satisfied by the chain database in production and by an
+// in-memory stand-in in tests so that the eviction and reload paths can be
+// exercised deterministically without a real database.
+type blockIndexStore interface {
+	// PutBlockNode persists a record for the given node.
+	PutBlockNode(node *blockNode) error
+
+	// FetchBlockNode returns the record previously stored for hash, along
+	// with the hash of its parent, if any.  It returns (nil, nil, false,
+	// nil) when no such record exists.
+	FetchBlockNode(hash *chainhash.Hash) (record *blockNodeRecord, parentHash *chainhash.Hash, exists bool, err error)
+}
+
+// chainTipEntry houses the most recently added chain tip at a given height
+// along with other known tips: any tip it displaced at that same height, plus
+// the other tips carried forward from the entry one height below when that
+// entry's tip was extended.  As a result, otherTips can hold tips at lower
+// heights than tip.
+type chainTipEntry struct {
+	tip       *blockNode
+	otherTips []*blockNode
+}
+
+// blockIndex provides facilities for keeping track of an in-memory indexed
+// view of the block chain.  Nodes that fall outside of both the current best
+// chain and the set of known chain tips (most commonly the interior nodes of
+// superseded side branches accumulated during IBD or a reorg) are dropped
+// from the direct-lookup index once a bounded LRU tracking them exceeds the
+// configured limit, and transparently reconstructed from the backing store
+// the next time they are looked up by hash.  The current best chain and all
+// known chain tips are always kept resident in the index so that tip
+// tracking and ancestor traversal never incur a load.
+//
+// Note that because ancestor traversal walks the parent and skip list
+// pointers directly, any node still reachable from the best chain tip or a
+// known tip remains pinned transitively regardless of whether it has been
+// dropped from the direct-lookup index, so an interior node of a side branch
+// only actually becomes eligible for garbage collection once its branch is
+// no longer a known tip; while the branch remains live, "eviction" merely
+// means it must be found again by walking down from its tip instead of by a
+// direct hash lookup.  loadNode takes advantage of this by first checking
+// whether a pinned tip's pointer chain already holds the requested node
+// before reconstructing one from the store, so that a still-live node is
+// never duplicated into two distinct objects.  Bounding the memory consumed
+// by main chain history as well as active side branches would additionally
+// require resolving parent and skip list pointers indirectly (by hash,
+// through the index) instead of holding them directly.
+//
+// Because a pinned node is never added to the eviction tracker in the
+// first place, setTip and addChainTip explicitly re-register a node with
+// the tracker via demote whenever they cause it to stop being pinned (a
+// reorg moving main chain ancestry away from it, or a chain tip being
+// superseded); otherwise it would never become eligible for eviction since
+// nothing else revisits its pinned status until it is looked up again by
+// hash.
+//
+// This structure is safe for concurrent access from multiple goroutines.
+type blockIndex struct {
+	// store houses the backing store used to load and persist nodes that
+	// are not currently resident in memory.  It may be nil, in which case
+	// the index behaves as an unbounded, fully in-memory index.
+	store blockIndexStore
+
+	sync.RWMutex
+	index     map[chainhash.Hash]*blockNode
+	chainTips map[int64]*chainTipEntry
+
+	// bestTip tracks the tip of the current best chain so residency checks
+	// can determine whether a node is an ancestor of it in O(log n) via the
+	// skip list instead of needing to keep every historical node pinned.
+	bestTip *blockNode
+
+	// cache tracks the recency of non-pinned nodes and drives eviction once
+	// maxEntries is exceeded.
+	cache *blockNodeLRU
+}
+
+// newBlockIndex returns a new empty instance of a block index.  The index
+// will be dynamically populated as block nodes are loaded from the database
+// and as new nodes are connected to the chain.
+func newBlockIndex(store blockIndexStore) *blockIndex {
+	return &blockIndex{
+		store:     store,
+		index:     make(map[chainhash.Hash]*blockNode),
+		chainTips: make(map[int64]*chainTipEntry),
+		cache:     newBlockNodeLRU(defaultBlockIndexCacheSize),
+	}
+}
+
+// blockNodeLRU is a bounded least-recently-used tracker for block nodes that
+// are eligible for eviction from the index (that is, nodes that are neither
+// part of the current best chain nor a known chain tip).
+type blockNodeLRU struct {
+	maxEntries int
+	ll         *list.List
+	elems      map[chainhash.Hash]*list.Element
+}
+
+// newBlockNodeLRU returns a new LRU tracker bounded to maxEntries entries.  A
+// maxEntries value <= 0 disables the bound entirely.
+func newBlockNodeLRU(maxEntries int) *blockNodeLRU {
+	return &blockNodeLRU{
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		elems:      make(map[chainhash.Hash]*list.Element),
+	}
+}
+
+// touch marks the given node as the most recently used entry, adding it to
+// the tracker if it is not already present.
+func (c *blockNodeLRU) touch(node *blockNode) {
+	if elem, ok := c.elems[node.hash]; ok {
+		c.ll.MoveToFront(elem)
+		return
+	}
+	c.elems[node.hash] = c.ll.PushFront(node)
+}
+
+// remove stops tracking the given node.
+func (c *blockNodeLRU) remove(node *blockNode) {
+	if elem, ok := c.elems[node.hash]; ok {
+		c.ll.Remove(elem)
+		delete(c.elems, node.hash)
+	}
+}
+
+// len returns the number of entries currently tracked.
+func (c *blockNodeLRU) len() int {
+	return c.ll.Len()
+}
+
+// isPinned returns whether the given node must be kept resident in memory
+// regardless of how recently it was used: either because it is an ancestor
+// of (or is) the current best chain tip, or because it is a currently known
+// chain tip (the head of any branch, including side branches).
+//
+// This function is not safe for concurrent access and must be called with
+// the index lock held.
+func (bi *blockIndex) isPinned(node *blockNode) bool {
+	if bi.bestTip != nil && node.height <= bi.bestTip.height &&
+		bi.bestTip.Ancestor(node.height) == node {
+		return true
+	}
+
+	// Tips are not only found in the entry at their own height since
+	// addChainTip carries other tips forward into higher entries.
+	for _, entry := range bi.chainTips {
+		if entry.tip == node {
+			return true
+		}
+		for _, n := range entry.otherTips {
+			if n == node {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cacheNode registers node as resident in the index and, when it is not
+// pinned, marks it as the most-recently-used entry in the eviction tracker.
+// It then enforces the configured cache bound, evicting the
+// least-recently-used unpinned nodes as necessary.
+//
+// This function is not safe for concurrent access and must be called with
+// the index lock held.
+func (bi *blockIndex) cacheNode(node *blockNode) {
+	bi.index[node.hash] = node
+	if !bi.isPinned(node) {
+		bi.cache.touch(node)
+	}
+	bi.enforceCapacity()
+}
+
+// demote re-registers node with the eviction tracker if it is no longer
+// pinned.  It must be called whenever an operation can cause a node that
+// was previously pinned (and therefore was never added to the tracker in
+// the first place, since cacheNode only tracks already-unpinned nodes) to
+// stop being pinned -- otherwise that node would remain resident forever,
+// since nothing else re-examines its pinned status until it is looked up
+// again by hash, which ordinary parent-pointer traversal never does.
+//
+// This function is not safe for concurrent access and must be called with
+// the index lock held.
+func (bi *blockIndex) demote(node *blockNode) {
+	if node == nil || bi.isPinned(node) {
+		return
+	}
+	bi.cache.touch(node)
+}
+
+// demoteStaleMainChain walks backwards from oldTip, re-registering each
+// node with the eviction tracker via demote until it reaches a node that is
+// still part of newTip's ancestry (the fork point between the two), to
+// account for the old best chain nodes beyond that point no longer being
+// pinned by virtue of main chain membership.
+//
+// This function is not safe for concurrent access and must be called with
+// the index lock held.
+func (bi *blockIndex) demoteStaleMainChain(oldTip, newTip *blockNode) {
+	for n := oldTip; n != nil; n = n.parent {
+		if newTip != nil && n.height <= newTip.height && newTip.Ancestor(n.height) == n {
+			break
+		}
+		bi.demote(n)
+	}
+}
+
+// enforceCapacity evicts the least-recently-used nodes that are not pinned
+// until the resident set is back within the configured bound.  Nodes that
+// become pinned since they were added to the eviction tracker (for example,
+// because they are now part of the current best chain) are skipped and
+// simply removed from the tracker instead of being evicted.
+//
+// This function is not safe for concurrent access and must be called with
+// the index lock held.
+func (bi *blockIndex) enforceCapacity() {
+	if bi.store == nil {
+		return
+	}
+	maxEntries := bi.cache.maxEntries
+	if maxEntries <= 0 {
+		return
+	}
+	for bi.cache.len() > maxEntries {
+		elem := bi.cache.ll.Back()
+		if elem == nil {
+			break
+		}
+		node := elem.Value.(*blockNode)
+		bi.cache.ll.Remove(elem)
+		delete(bi.cache.elems, node.hash)
+
+		if bi.isPinned(node) {
+			// No longer a legitimate eviction candidate; simply drop it
+			// from the tracker without evicting it from the index.
+			continue
+		}
+		delete(bi.index, node.hash)
+	}
+}
+
+// LookupNode returns the block node identified by the provided hash.  It
+// will be loaded from the backing store, if one is configured and the node
+// is not already resident in memory.  This returns nil when no block node
+// with the provided hash exists.
+//
+// This function is safe for concurrent access.
+func (bi *blockIndex) LookupNode(hash *chainhash.Hash) *blockNode {
+	bi.Lock()
+	defer bi.Unlock()
+	return bi.lookupNode(hash)
+}
+
+// lookupNode is the internal implementation of LookupNode that must be
+// called with the index lock held for writing since it may mutate the
+// eviction tracker and load nodes from the backing store.
+func (bi *blockIndex) lookupNode(hash *chainhash.Hash) *blockNode {
+	if node, ok := bi.index[*hash]; ok {
+		if !bi.isPinned(node) {
+			bi.cache.touch(node)
+		}
+		return node
+	}
+
+	if bi.store == nil {
+		return nil
+	}
+	return bi.loadNode(hash)
+}
+
+// loadNode attempts to reconstruct a block node from the backing store,
+// recursively resolving its parent pointer if necessary, and caches the
+// result.  It returns nil when the store has no record for the hash.
+//
+// Before reconstructing anything, it first checks whether the requested
+// node is still a live object reachable by walking down from a pinned tip
+// (this happens when a node has been dropped from the direct-lookup index
+// while its branch remains a known tip) and, if so, reuses that object
+// instead of fabricating a second one for the same logical block, which
+// would otherwise break the pointer-identity comparisons the rest of the
+// package relies on.
+func (bi *blockIndex) loadNode(hash *chainhash.Hash) *blockNode {
+	record, parentHash, exists, err := bi.store.FetchBlockNode(hash)
+	if err != nil || !exists {
+		return nil
+	}
+
+	if node := bi.findResidentAncestor(hash, int64(record.header.Height)); node != nil {
+		bi.cacheNode(node)
+		return node
+	}
+
+	var parent *blockNode
+	if parentHash != nil {
+		parent = bi.lookupNode(parentHash)
+		if parent == nil {
+			return nil
+		}
+	}
+
+	node := newBlockNode(&record.header, parent)
+	node.status = record.status
+	node.receivedOrderID = record.receivedOrderID
+	node.workSum = record.workSum
+
+	bi.cacheNode(node)
+	return node
+}
+
+// findResidentAncestor searches the pointer chains hanging off of the
+// current best chain tip and every known chain tip for an already-resident
+// node at the given height matching hash, returning it if found.  This lets
+// loadNode recognize a node that is still pinned in memory transitively
+// through a tip's parent pointers even though it was previously dropped
+// from the direct-lookup index, instead of constructing a duplicate object
+// for it.
+//
+// This function is not safe for concurrent access and must be called with
+// the index lock held.
+func (bi *blockIndex) findResidentAncestor(hash *chainhash.Hash, height int64) *blockNode {
+	if bi.bestTip != nil && height <= bi.bestTip.height {
+		if n := bi.bestTip.Ancestor(height); n != nil && n.hash == *hash {
+			return n
+		}
+	}
+	for _, entry := range bi.chainTips {
+		if height <= entry.tip.height {
+			if n := entry.tip.Ancestor(height); n != nil && n.hash == *hash {
+				return n
+			}
+		}
+		for _, tip := range entry.otherTips {
+			if height <= tip.height {
+				if n := tip.Ancestor(height); n != nil && n.hash == *hash {
+					return n
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// HaveBlock returns whether or not the block index contains the provided
+// hash, either resident in memory or reloadable from the backing store.
+//
+// This function is safe for concurrent access.
+func (bi *blockIndex) HaveBlock(hash *chainhash.Hash) bool {
+	return bi.LookupNode(hash) != nil
+}
+
+// AddNode adds the provided node to the block index and marks it resident,
+// persisting it to the backing store, if configured, so that it can be
+// reloaded later after being evicted.  Duplicate adds of an existing node
+// are ignored.
+//
+// This function is safe for concurrent access.
+func (bi *blockIndex) AddNode(node *blockNode) {
+	bi.Lock()
+	defer bi.Unlock()
+	bi.addNode(node)
+}
+
+// addNode is the internal implementation of AddNode that must be called
+// with the index lock held for writing.
+func (bi *blockIndex) addNode(node *blockNode) {
+	if _, exists := bi.index[node.hash]; exists {
+		return
+	}
+
+	if bi.store != nil {
+		// Errors persisting the node are intentionally not fatal here; the
+		// node remains fully usable from memory and will simply not survive
+		// an eviction if the store is unavailable.
+		_ = bi.store.PutBlockNode(node)
+	}
+
+	// addChainTip is run before cacheNode so that a node which becomes a
+	// chain tip the moment it is added is already recognized as pinned by
+	// the time cacheNode checks, instead of briefly being registered with
+	// the eviction tracker and only dropping back out of it once it is
+	// evicted from the tracker (which, since it's pinned, just forgets it
+	// was ever tracked rather than evicting it from the index).
+	bi.addChainTip(node)
+	bi.cacheNode(node)
+}
+
+// setTip records node as the tip of the current best chain, which both
+// ensures its full ancestry stays resident and allows previously-pinned
+// nodes from a now-stale branch to become eligible for eviction.
+//
+// This function is safe for concurrent access.
+func (bi *blockIndex) setTip(node *blockNode) {
+	bi.Lock()
+	defer bi.Unlock()
+	oldTip := bi.bestTip
+	bi.bestTip = node
+	bi.demoteStaleMainChain(oldTip, node)
+	bi.enforceCapacity()
+}
+
+// addChainTip updates the chain tip tracking to account for the newly added
+// node, including resolving the case where the new node supersedes its
+// parent as a tip and the case where it begins an entirely new branch.
+//
+// This function is not safe for concurrent access and must be called with
+// the index lock held.
+func (bi *blockIndex) addChainTip(tip *blockNode) {
+	newEntry := &chainTipEntry{tip: tip}
+	if existing, ok := bi.chainTips[tip.height]; ok && existing.tip != tip.parent {
+		newEntry.otherTips = append(newEntry.otherTips, existing.tip)
+		newEntry.otherTips = append(newEntry.otherTips, existing.otherTips...)
+	}
+	bi.chainTips[tip.height] = newEntry
+
+	if tip.parent == nil {
+		return
+	}
+
+	parentEntry, ok := bi.chainTips[tip.parent.height]
+	if !ok {
+		return
+	}
+
+	switch {
+	case parentEntry.tip == tip.parent:
+		delete(bi.chainTips, tip.parent.height)
+		bi.demote(tip.parent)
+
+	default:
+		indexToRemove := -1
+		for i, n := range parentEntry.otherTips {
+			if n == tip.parent {
+				indexToRemove = i
+				break
+			}
+		}
+		if indexToRemove == -1 {
+			return
+		}
+
+		otherTips := parentEntry.otherTips
+		copy(otherTips[indexToRemove:], otherTips[indexToRemove+1:])
+		otherTips[len(otherTips)-1] = nil
+		parentEntry.otherTips = otherTips[:len(otherTips)-1]
+		bi.demote(tip.parent)
+		return
+	}
+
+	if len(parentEntry.otherTips) == 0 {
+		return
+	}
+	entry := bi.chainTips[tip.height]
+	entry.otherTips = append(entry.otherTips, parentEntry.otherTips...)
+}
+
+// compareHashesAsUint256LE compares the two passed hashes as though they
+// were 256-bit unsigned integers encoded in little-endian, returning -1 when
+// hashA is numerically less than hashB, 1 when it is greater, and 0 when
+// they are equal.
+func compareHashesAsUint256LE(hashA, hashB *chainhash.Hash) int {
+	for i := chainhash.HashSize - 1; i >= 0; i-- {
+		switch {
+		case hashA[i] < hashB[i]:
+			return -1
+		case hashA[i] > hashB[i]:
+			return 1
+		}
+	}
+	return 0
+}
+
+// workSorterLess returns whether block node a should sort before block node
+// b when ranking candidate chain tips from worst to best.  Cumulative work
+// is the primary sort key, with ties broken first in favor of the node
+// backed by stored block data, then in favor of the node that was received
+// first, and finally, deterministically, in favor of the node with the
+// numerically smaller hash.
+func workSorterLess(a, b *blockNode) bool {
+	if workCmp := a.workSum.Cmp(b.workSum); workCmp != 0 {
+		return workCmp < 0
+	}
+
+	aHasData := a.status&statusDataStored != 0
+	bHasData := b.status&statusDataStored != 0
+	if aHasData != bHasData {
+		return bHasData
+	}
+
+	if a.receivedOrderID != b.receivedOrderID {
+		return a.receivedOrderID > b.receivedOrderID
+	}
+
+	return compareHashesAsUint256LE(&a.hash, &b.hash) > 0
+}
@@ -0,0 +1,246 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/chaincfg/v3"
+)
+
+// memBlockIndexStore is a trivial, fully in-memory implementation of
+// blockIndexStore used to exercise the eviction and on-demand reload paths
+// of the block index without requiring a real database.
+type memBlockIndexStore struct {
+	mtx     sync.Mutex
+	records map[chainhash.Hash]*blockNodeRecord
+	parents map[chainhash.Hash]*chainhash.Hash
+}
+
+func newMemBlockIndexStore() *memBlockIndexStore {
+	return &memBlockIndexStore{
+		records: make(map[chainhash.Hash]*blockNodeRecord),
+		parents: make(map[chainhash.Hash]*chainhash.Hash),
+	}
+}
+
+func (s *memBlockIndexStore) PutBlockNode(node *blockNode) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var parentHash *chainhash.Hash
+	if node.parent != nil {
+		h := node.parent.hash
+		parentHash = &h
+	}
+	s.records[node.hash] = &blockNodeRecord{
+		header:          node.Header(),
+		workSum:         node.workSum,
+		status:          node.status,
+		receivedOrderID: node.receivedOrderID,
+	}
+	s.parents[node.hash] = parentHash
+	return nil
+}
+
+func (s *memBlockIndexStore) FetchBlockNode(hash *chainhash.Hash) (*blockNodeRecord, *chainhash.Hash, bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	record, ok := s.records[*hash]
+	if !ok {
+		return nil, nil, false, nil
+	}
+	return record, s.parents[*hash], true, nil
+}
+
+// connectFakeNode is a small test helper that adds node to the index, makes
+// it the new best chain tip, and keeps the chain view and index's notion of
+// the best tip in sync the same way the full chain connection logic would.
+func connectFakeNode(bc *BlockChain, node *blockNode) {
+	bc.index.AddNode(node)
+	bc.bestChain.SetTip(node)
+	bc.index.setTip(node)
+}
+
+// TestBlockIndexLRUEviction ensures that block nodes which fall outside of
+// both the current best chain and the set of known chain tips are evicted
+// from memory once a small cache bound is exceeded, and that they can still
+// be reached afterwards: by LookupNode and HeaderByHash through the backing
+// store, and by Ancestor traversal through the pointers of the side branch
+// tip.
+func TestBlockIndexLRUEviction(t *testing.T) {
+	params := chaincfg.RegNetParams()
+	bc := newFakeChain(params)
+	genesis := bc.bestChain.Tip()
+
+	// Swap in a backing store and a tiny cache bound so evictions are
+	// forced almost immediately.
+	store := newMemBlockIndexStore()
+	bc.index.store = store
+	bc.index.cache = newBlockNodeLRU(3)
+	if err := store.PutBlockNode(genesis); err != nil {
+		t.Fatalf("unexpected error persisting genesis: %v", err)
+	}
+
+	// Extend the main chain well past the cache bound.  Every node here
+	// remains an ancestor of the tip, so none of them should ever be
+	// evicted regardless of the tiny cache bound.
+	mainBranch := chainedFakeNodes(genesis, 20)
+	for _, node := range mainBranch {
+		connectFakeNode(bc, node)
+	}
+
+	bc.index.RLock()
+	residentMain := len(bc.index.index)
+	bc.index.RUnlock()
+	wantResidentMain := len(mainBranch) + 1 // +1 for genesis
+	if residentMain != wantResidentMain {
+		t.Fatalf("expected all %d best chain nodes to remain resident, but "+
+			"only %d are", wantResidentMain, residentMain)
+	}
+
+	// Fork off of an early main chain node to create a side branch that is
+	// never made the best chain tip.  Only its own tip is pinned via the
+	// chain tip tracking, so the interior nodes should be dropped from the
+	// direct-lookup index as soon as the cache bound is exceeded.  They
+	// remain reachable by walking down from the still-live side branch tip,
+	// though, since that tip itself stays pinned.
+	sideBranch := chainedFakeNodes(mainBranch[4], 10)
+	for _, node := range sideBranch {
+		bc.index.AddNode(node)
+	}
+
+	evictedHash := sideBranch[0].hash
+	bc.index.RLock()
+	_, stillResident := bc.index.index[evictedHash]
+	bc.index.RUnlock()
+	if stillResident {
+		t.Fatalf("expected side branch interior node at height %d to have "+
+			"been evicted", sideBranch[0].height)
+	}
+
+	sideTipHash := branchTip(sideBranch).hash
+	bc.index.RLock()
+	_, tipResident := bc.index.index[sideTipHash]
+	bc.index.RUnlock()
+	if !tipResident {
+		t.Fatal("expected side branch tip to remain resident as a known " +
+			"chain tip")
+	}
+
+	// LookupNode on the evicted node should transparently reload it (and
+	// its ancestors, as needed) from the backing store.
+	reloaded := bc.index.LookupNode(&evictedHash)
+	if reloaded == nil {
+		t.Fatal("LookupNode: failed to reload evicted node from store")
+	}
+	if reloaded.hash != evictedHash {
+		t.Fatalf("LookupNode: mismatched hash -- got %s, want %s",
+			reloaded.hash, evictedHash)
+	}
+
+	// Ancestor traversal from the still-resident side branch tip should
+	// produce the correct node by walking its parent and skip list pointers.
+	sideTip := bc.index.LookupNode(&sideTipHash)
+	ancestor := sideTip.Ancestor(mainBranch[4].height + 1)
+	if ancestor == nil || ancestor.hash != sideBranch[0].hash {
+		t.Fatal("Ancestor: failed to reconstruct evicted ancestor")
+	}
+
+	// Because the side branch tip is still pinned, the interior node is
+	// never actually unreachable -- it is only missing from the direct
+	// lookup index.  LookupNode must recognize it is still live via the
+	// tip's pointer chain and return that same object rather than
+	// fabricating a second, distinct node for the same hash.
+	if reloaded != ancestor {
+		t.Fatal("LookupNode: reconstructed a duplicate node object for a " +
+			"hash still reachable through a pinned tip")
+	}
+
+	// HeaderByHash should also continue to work through the cache.
+	header, err := bc.HeaderByHash(&evictedHash)
+	if err != nil {
+		t.Fatalf("HeaderByHash: unexpected error: %v", err)
+	}
+	if header.BlockHash() != evictedHash {
+		t.Fatalf("HeaderByHash: mismatched header -- got hash %s, want %s",
+			header.BlockHash(), evictedHash)
+	}
+}
+
+// TestBlockIndexLRUReorgEviction ensures that nodes belonging to a branch
+// that is abandoned by a reorg become eligible for eviction once they are
+// no longer part of either the best chain or a known chain tip, even though
+// they were resident (and therefore pinned, and never added to the
+// eviction tracker) for their entire lifetime up to that point.
+func TestBlockIndexLRUReorgEviction(t *testing.T) {
+	params := chaincfg.RegNetParams()
+	bc := newFakeChain(params)
+	genesis := bc.bestChain.Tip()
+
+	store := newMemBlockIndexStore()
+	bc.index.store = store
+	bc.index.cache = newBlockNodeLRU(3)
+	if err := store.PutBlockNode(genesis); err != nil {
+		t.Fatalf("unexpected error persisting genesis: %v", err)
+	}
+
+	// Build up a 20 block main chain.  Every one of these nodes is pinned,
+	// and therefore never added to the eviction tracker, for as long as it
+	// remains part of the best chain.
+	oldChain := chainedFakeNodes(genesis, 20)
+	for _, node := range oldChain {
+		connectFakeNode(bc, node)
+	}
+
+	// Fork off of an early block and extend the new branch past the old
+	// chain's tip, then reorg onto it.  The old chain's former tip remains
+	// a known chain tip of the now-abandoned branch and stays pinned for
+	// that reason, same as any other side branch tip, but everything
+	// between the fork point and that tip is now an interior node of the
+	// abandoned branch and should become eligible for eviction.
+	oldTip := branchTip(oldChain)
+	newChain := chainedFakeNodes(oldChain[0], 25)
+	for _, node := range newChain {
+		connectFakeNode(bc, node)
+	}
+
+	// Add further blocks on top of the new tip to give enforceCapacity
+	// plenty of additional opportunities to run.
+	moreChain := chainedFakeNodes(branchTip(newChain), 10)
+	for _, node := range moreChain {
+		connectFakeNode(bc, node)
+	}
+
+	// The interior nodes of the abandoned branch are only bounded by the
+	// cache limit, not evicted unconditionally, so at most maxEntries of
+	// them may remain resident.  Before the fix, none of them were ever
+	// added to the eviction tracker in the first place (since they were
+	// pinned for their entire lifetime up until the reorg), so all 18
+	// would still be resident here.
+	interior := oldChain[1 : len(oldChain)-1]
+	bc.index.RLock()
+	residentInterior := 0
+	for _, node := range interior {
+		if _, resident := bc.index.index[node.hash]; resident {
+			residentInterior++
+		}
+	}
+	_, oldTipResident := bc.index.index[oldTip.hash]
+	bc.index.RUnlock()
+
+	if residentInterior > bc.index.cache.maxEntries {
+		t.Fatalf("expected at most %d of the %d abandoned interior nodes "+
+			"to remain resident, but %d are", bc.index.cache.maxEntries,
+			len(interior), residentInterior)
+	}
+	if !oldTipResident {
+		t.Fatal("expected the old chain's former tip to remain resident " +
+			"as a known chain tip of the abandoned branch")
+	}
+}
@@ -0,0 +1,374 @@
+// Copyright (c) 2015-2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/decred/dcrd/blockchain/standalone/v2"
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/wire"
+)
+
+// medianTimeBlocks is the number of previous blocks which should be used to
+// calculate the median time used to validate block timestamps.
+const medianTimeBlocks = 11
+
+// blockStatus is a bit field representing the validation state of the block.
+type blockStatus byte
+
+const (
+	// statusDataStored indicates that the block's payload is stored on disk.
+	statusDataStored blockStatus = 1 << iota
+
+	// statusValid indicates that the block has been fully validated.
+	statusValid
+
+	// statusValidateFailed indicates that the block has failed validation.
+	statusValidateFailed
+
+	// statusInvalidAncestor indicates that one of the block's ancestors has
+	// failed validation, rendering the block itself invalid.
+	statusInvalidAncestor
+
+	// statusNone indicates that the block has no validation state at all.
+	// This is used to reset the status of a node in some cases.
+	statusNone blockStatus = 0
+)
+
+// KnownValid returns whether the block is known to be valid.  This will
+// return false for a valid block that has not been fully validated yet.
+func (status blockStatus) KnownValid() bool {
+	return status&statusValid != 0
+}
+
+// KnownInvalid returns whether the block is known to be invalid, either
+// because it was directly rejected or one of its ancestors was rejected.
+func (status blockStatus) KnownInvalid() bool {
+	return status&(statusValidateFailed|statusInvalidAncestor) != 0
+}
+
+// blockNode represents a block within the block chain and is primarily used
+// to aid in selecting the best chain to be the main chain.  The main chain is
+// stored into the block database.
+type blockNode struct {
+	// parent is the parent block for this node.
+	parent *blockNode
+
+	// skipToAncestor points to a block with a lower height that can be used
+	// in conjunction with the parent pointers to accelerate O(log n)
+	// ancestor traversal instead of O(n) traversal by walking parent
+	// pointers one at a time.  This is populated when the node is created
+	// per the skip list algorithm used by Bitcoin Core.
+	skipToAncestor *blockNode
+
+	// hash is the hash of the block this node represents.
+	hash chainhash.Hash
+
+	// workSum is the total amount of work in the chain up to and including
+	// this node.
+	workSum *big.Int
+
+	// height is the position in the block chain.
+	height int64
+
+	// receivedOrderID is a unique, monotonically increasing identifier that
+	// is assigned when the node is created and used to break ties between
+	// nodes that otherwise compare as equal so that block processing order
+	// is deterministic.
+	receivedOrderID uint32
+
+	// status is a bitfield representing the validation state of the block.
+	status blockStatus
+
+	// Fields used to reconstruct the header from a node without having to
+	// hold on to the entire header itself.
+	version      int32
+	bits         uint32
+	sbits        int64
+	nonce        uint32
+	stakeVersion uint32
+	size         uint32
+	voteBits     uint16
+	finalState   [6]byte
+	voters       uint16
+	freshStake   uint8
+	revocations  uint8
+	poolSize     uint32
+	timestamp    int64
+	merkleRoot   chainhash.Hash
+	stakeRoot    chainhash.Hash
+	extraData    [32]byte
+}
+
+// initBlockNode initializes a block node from the given header and parent
+// node.  The workSum and receivedOrderID fields must be set by the caller as
+// they depend on state outside of the header itself.
+func initBlockNode(node *blockNode, blockHeader *wire.BlockHeader, parent *blockNode) {
+	*node = blockNode{
+		hash:         blockHeader.BlockHash(),
+		workSum:      standalone.CalcWork(blockHeader.Bits),
+		version:      blockHeader.Version,
+		bits:         blockHeader.Bits,
+		sbits:        blockHeader.SBits,
+		nonce:        blockHeader.Nonce,
+		stakeVersion: blockHeader.StakeVersion,
+		size:         blockHeader.Size,
+		voteBits:     blockHeader.VoteBits,
+		finalState:   blockHeader.FinalState,
+		voters:       blockHeader.Voters,
+		freshStake:   blockHeader.FreshStake,
+		revocations:  blockHeader.Revocations,
+		poolSize:     blockHeader.PoolSize,
+		timestamp:    blockHeader.Timestamp.Unix(),
+		merkleRoot:   blockHeader.MerkleRoot,
+		stakeRoot:    blockHeader.StakeRoot,
+		extraData:    blockHeader.ExtraData,
+	}
+
+	if parent != nil {
+		node.parent = parent
+		node.height = parent.height + 1
+		node.workSum = node.workSum.Add(parent.workSum, node.workSum)
+	}
+	node.skipToAncestor = calcSkipListNode(node)
+}
+
+// newBlockNode returns a new block node for the given block header and
+// parent node, calculating the height and workSum from the parent if it is
+// not nil.
+func newBlockNode(blockHeader *wire.BlockHeader, parent *blockNode) *blockNode {
+	var node blockNode
+	initBlockNode(&node, blockHeader, parent)
+	return &node
+}
+
+// Header constructs a block header from the node and returns it.
+//
+// This function is safe for concurrent access.
+func (node *blockNode) Header() wire.BlockHeader {
+	var prevHash chainhash.Hash
+	if node.parent != nil {
+		prevHash = node.parent.hash
+	}
+	return wire.BlockHeader{
+		Version:      node.version,
+		PrevBlock:    prevHash,
+		MerkleRoot:   node.merkleRoot,
+		StakeRoot:    node.stakeRoot,
+		VoteBits:     node.voteBits,
+		FinalState:   node.finalState,
+		Voters:       node.voters,
+		FreshStake:   node.freshStake,
+		Revocations:  node.revocations,
+		PoolSize:     node.poolSize,
+		Bits:         node.bits,
+		SBits:        node.sbits,
+		Height:       uint32(node.height),
+		Size:         node.size,
+		Timestamp:    time.Unix(node.timestamp, 0),
+		Nonce:        node.nonce,
+		ExtraData:    node.extraData,
+		StakeVersion: node.stakeVersion,
+	}
+}
+
+// invertLowestOne turns the lowest '1' bit in the binary representation of a
+// number into a '0'.
+func invertLowestOne(n int64) int64 {
+	return n & (n - 1)
+}
+
+// getSkipHeight returns the height of the block that the skip list pointer
+// of the block at the provided height should point to in order to achieve
+// the desired O(log n) traversal properties.  It is a port of the same
+// function used by Bitcoin Core's implementation of the skip list.
+func getSkipHeight(height int64) int64 {
+	if height < 2 {
+		return 0
+	}
+
+	// Determine which height to jump back to.  Any number strictly lower
+	// than height is acceptable, but the following expression seeks to
+	// minimize the number of nodes visited on average.
+	if height&1 != 0 {
+		return invertLowestOne(invertLowestOne(height-1)) + 1
+	}
+	return invertLowestOne(height)
+}
+
+// calcSkipListNode returns the node that the skip list pointer of the
+// provided node should reference per the skip list algorithm.  The node must
+// already have its parent and height set.
+func calcSkipListNode(node *blockNode) *blockNode {
+	if node.parent == nil {
+		return nil
+	}
+
+	skipHeight := getSkipHeight(node.height)
+	if node.parent.height == skipHeight {
+		return node.parent
+	}
+	return node.parent.Ancestor(skipHeight)
+}
+
+// Ancestor returns the ancestor block node at the provided height by
+// following the chain backwards from this node.  The returned block will be
+// nil when a height is requested that is after the height of the passed
+// node or is less than zero.
+//
+// This function makes use of the skip list to accelerate traversal to
+// O(log n) rather than the naive O(n) approach of walking parent pointers
+// one at a time.
+//
+// This function is safe for concurrent access.
+func (node *blockNode) Ancestor(height int64) *blockNode {
+	if height < 0 || height > node.height {
+		return nil
+	}
+
+	n := node
+	heightWalk := node.height
+	for heightWalk > height {
+		heightSkip := getSkipHeight(heightWalk)
+		heightSkipPrev := getSkipHeight(heightWalk - 1)
+		if n.skipToAncestor != nil && (heightSkip == height ||
+			(heightSkip > height && !(heightSkipPrev < heightSkip-2 && heightSkipPrev >= height))) {
+			n = n.skipToAncestor
+			heightWalk = heightSkip
+			continue
+		}
+		n = n.parent
+		heightWalk--
+	}
+	return n
+}
+
+// SplitPoint returns the most recent common ancestor of the node and the
+// passed other node, regardless of which, if either, is on the main chain.
+// It returns nil when the two nodes belong to entirely disjoint chains (for
+// example, chains rooted at different genesis blocks) and can otherwise
+// return the node itself when the two nodes are identical.
+//
+// The algorithm walks both nodes back to the height of whichever one is
+// higher using the skip list, and then alternates jumping both to their
+// respective skip list ancestor when those differ or stepping both back to
+// their parent otherwise, which converges to the fork point in O(log n)
+// time.
+//
+// This function is safe for concurrent access.
+func (node *blockNode) SplitPoint(other *blockNode) *blockNode {
+	if node == nil || other == nil {
+		return nil
+	}
+
+	a, b := node, other
+	if a.height > b.height {
+		a = a.Ancestor(b.height)
+	} else if b.height > a.height {
+		b = b.Ancestor(a.height)
+	}
+
+	for a != b {
+		if a == nil || b == nil {
+			return nil
+		}
+		if a.skipToAncestor != b.skipToAncestor {
+			a, b = a.skipToAncestor, b.skipToAncestor
+		} else {
+			a, b = a.parent, b.parent
+		}
+	}
+	return a
+}
+
+// RelativeAncestor returns the ancestor block node a relative 'distance'
+// blocks before this node.  This is equivalent to calling Ancestor with
+// the node's height minus provided distance.
+//
+// This function is safe for concurrent access.
+func (node *blockNode) RelativeAncestor(distance int64) *blockNode {
+	return node.Ancestor(node.height - distance)
+}
+
+// CalcPastMedianTime calculates the median time of the previous few blocks
+// prior to, and including, the block node.
+//
+// This function is safe for concurrent access.
+func (node *blockNode) CalcPastMedianTime() time.Time {
+	// Create a slice of the previous few timestamps used to calculate the
+	// median per the number defined by the constant medianTimeBlocks.
+	timestamps := make([]int64, medianTimeBlocks)
+	numNodes := 0
+	iterNode := node
+	for i := 0; i < medianTimeBlocks && iterNode != nil; i++ {
+		timestamps[i] = iterNode.timestamp
+		numNodes++
+
+		iterNode = iterNode.parent
+	}
+	timestamps = timestamps[:numNodes]
+
+	sort.Sort(timeSorter(timestamps))
+
+	medianTimestamp := timestamps[numNodes/2]
+	return time.Unix(medianTimestamp, 0)
+}
+
+// SuitableBlock returns the median-by-timestamp of the node and its two most
+// recent ancestors, which is the primitive modern difficulty adjustment
+// algorithms, such as ASERT, use in place of a single block's own timestamp
+// to blunt the effect of a miner manipulating its timestamp.
+//
+// When the node has fewer than two ancestors, the closest available
+// approximation is returned instead: the node itself when it has no parent
+// at all, or its parent when it has exactly one ancestor.
+//
+// This function is safe for concurrent access.
+func (node *blockNode) SuitableBlock() *blockNode {
+	if node.parent == nil {
+		return node
+	}
+	if node.parent.parent == nil {
+		return node.parent
+	}
+
+	blocks := [3]*blockNode{node.parent.parent, node.parent, node}
+	if blocks[0].timestamp > blocks[1].timestamp {
+		blocks[0], blocks[1] = blocks[1], blocks[0]
+	}
+	if blocks[1].timestamp > blocks[2].timestamp {
+		blocks[1], blocks[2] = blocks[2], blocks[1]
+	}
+	if blocks[0].timestamp > blocks[1].timestamp {
+		blocks[0], blocks[1] = blocks[1], blocks[0]
+	}
+
+	return blocks[1]
+}
+
+// timeSorter implements sort.Interface to allow a slice of timestamps to be
+// sorted.
+type timeSorter []int64
+
+// Len returns the number of timestamps in the slice.  It is part of the
+// sort.Interface implementation.
+func (s timeSorter) Len() int {
+	return len(s)
+}
+
+// Swap swaps the timestamps at the passed indices.  It is part of the
+// sort.Interface implementation.
+func (s timeSorter) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// Less returns whether the timestamp with index i should sort before the
+// timestamp with index j.  It is part of the sort.Interface implementation.
+func (s timeSorter) Less(i, j int) bool {
+	return s[i] < s[j]
+}
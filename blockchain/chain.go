@@ -0,0 +1,59 @@
+// Copyright (c) 2013-2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"fmt"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/wire"
+)
+
+// BlockChain provides functions for working with the Decred block chain.
+// It includes functionality such as rejecting duplicate blocks, ensuring
+// blocks follow all rules, orphan handling, and best chain selection with
+// reorganization.
+type BlockChain struct {
+	chainParams *chaincfg.Params
+	index       *blockIndex
+	bestChain   *chainView
+}
+
+// FindFork returns the final common block between the chains represented by
+// the two passed hashes, or nil if either hash does not correspond to a
+// known block.  The two blocks may be on the same branch, on entirely
+// disjoint branches, or anywhere else in the block index, including in side
+// chains that are not part of the current best chain.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) FindFork(hashA, hashB *chainhash.Hash) *wire.BlockHeader {
+	nodeA := b.index.LookupNode(hashA)
+	nodeB := b.index.LookupNode(hashB)
+	if nodeA == nil || nodeB == nil {
+		return nil
+	}
+
+	fork := nodeA.SplitPoint(nodeB)
+	if fork == nil {
+		return nil
+	}
+	header := fork.Header()
+	return &header
+}
+
+// HeaderByHash returns the block header identified by the given hash or an
+// error if it doesn't exist.  Note that this will return headers from both
+// the current main chain and any side chains.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) HeaderByHash(hash *chainhash.Hash) (wire.BlockHeader, error) {
+	node := b.index.LookupNode(hash)
+	if node == nil {
+		return wire.BlockHeader{}, fmt.Errorf("block %s is not known", hash)
+	}
+
+	return node.Header(), nil
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2015-2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import "sync"
+
+// chainView provides a flat view of the current best chain for fast O(1)
+// access to the block at any given height as well as the tip of the chain.
+// It is kept in sync with the block index as blocks are connected to, and
+// disconnected from, the best chain.
+type chainView struct {
+	sync.Mutex
+	nodes []*blockNode
+}
+
+// newChainView returns a new chain view rooted at the provided tip, or an
+// empty view when tip is nil.
+func newChainView(tip *blockNode) *chainView {
+	c := &chainView{}
+	c.setTip(tip)
+	return c
+}
+
+// Tip returns the tip of the chain view, or nil when the view is empty.
+//
+// This function is safe for concurrent access.
+func (c *chainView) Tip() *blockNode {
+	c.Lock()
+	defer c.Unlock()
+	return c.tip()
+}
+
+// tip is the internal implementation of Tip that must be called with the
+// view mutex held.
+func (c *chainView) tip() *blockNode {
+	if len(c.nodes) == 0 {
+		return nil
+	}
+	return c.nodes[len(c.nodes)-1]
+}
+
+// NodeByHeight returns the block node at the provided height.  It returns
+// nil when no node exists at that height in the view.
+//
+// This function is safe for concurrent access.
+func (c *chainView) NodeByHeight(height int64) *blockNode {
+	c.Lock()
+	defer c.Unlock()
+	if height < 0 || height >= int64(len(c.nodes)) {
+		return nil
+	}
+	return c.nodes[height]
+}
+
+// SetTip sets the chain view to the provided tip, rebuilding the height
+// index from the tip backwards.
+//
+// This function is safe for concurrent access.
+func (c *chainView) SetTip(node *blockNode) {
+	c.Lock()
+	defer c.Unlock()
+	c.setTip(node)
+}
+
+// setTip is the internal implementation of SetTip that must be called with
+// the view mutex held.
+func (c *chainView) setTip(node *blockNode) {
+	if node == nil {
+		c.nodes = nil
+		return
+	}
+
+	needed := node.height + 1
+	if int64(cap(c.nodes)) < needed {
+		nodes := make([]*blockNode, needed)
+		copy(nodes, c.nodes)
+		c.nodes = nodes
+	} else {
+		c.nodes = c.nodes[0:needed]
+	}
+
+	for node != nil && c.nodes[node.height] != node {
+		c.nodes[node.height] = node
+		node = node.parent
+	}
+}
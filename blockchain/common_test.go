@@ -0,0 +1,124 @@
+// Copyright (c) 2016-2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/wire"
+)
+
+// fakeNodeCounter and fakeOrderCounter are used by newFakeNode to ensure
+// that manufactured nodes with otherwise identical fields still hash
+// uniquely and receive a deterministic, increasing received order.
+var (
+	fakeNodeCounter  uint32
+	fakeOrderCounter uint32
+)
+
+// mustParseHash converts the passed big-endian hex string into a
+// chainhash.Hash and will panic if there is an error.  It is only used with
+// hard-coded hashes in test source.
+func mustParseHash(s string) *chainhash.Hash {
+	hash, err := chainhash.NewHashFromStr(s)
+	if err != nil {
+		panic("invalid hash in test source: " + s)
+	}
+	return hash
+}
+
+// newFakeChain returns a chain that is usable for syntactic block processing
+// tests.  It has a single node that is the passed params genesis block.
+func newFakeChain(params *chaincfg.Params) *BlockChain {
+	node := newBlockNode(&params.GenesisBlock.Header, nil)
+	node.status = statusDataStored | statusValid
+
+	index := newBlockIndex(nil)
+	index.AddNode(node)
+	index.bestTip = node
+
+	return &BlockChain{
+		chainParams: params,
+		index:       index,
+		bestChain:   newChainView(node),
+	}
+}
+
+// newFakeNode creates a block node connected to the passed parent with the
+// provided fields populated and fabricated state necessary to be used as a
+// node in the block index.  Note that this function is only used in tests,
+// and therefore it is not a typically sanctioned way to create a node.
+func newFakeNode(parent *blockNode, blockVersion int32, bits uint32, nonce uint32, timestamp time.Time) *blockNode {
+	var height uint32
+	if parent != nil {
+		height = uint32(parent.height + 1)
+	}
+
+	header := wire.BlockHeader{
+		Version:   blockVersion,
+		Height:    height,
+		Bits:      bits,
+		Nonce:     nonce,
+		Timestamp: timestamp,
+	}
+	if parent != nil {
+		header.PrevBlock = parent.hash
+	}
+
+	// Perturb the header with a monotonically increasing counter so that
+	// otherwise-identical fake nodes still produce unique hashes.
+	binary.LittleEndian.PutUint32(header.ExtraData[:4],
+		atomic.AddUint32(&fakeNodeCounter, 1))
+
+	node := newBlockNode(&header, parent)
+	node.status = statusDataStored | statusValid
+	node.receivedOrderID = atomic.AddUint32(&fakeOrderCounter, 1)
+	return node
+}
+
+// chainedFakeNodes returns the specified number of nodes constructed such
+// that each subsequent node points to the previous one to create a chain.
+// The first node will point to the passed parent, which can be nil if
+// desired.
+func chainedFakeNodes(parent *blockNode, numNodes int) []*blockNode {
+	nodes := make([]*blockNode, numNodes)
+	tip := parent
+	blockTime := time.Now()
+	if tip != nil {
+		blockTime = time.Unix(tip.timestamp, 0)
+	}
+	for i := 0; i < numNodes; i++ {
+		blockTime = blockTime.Add(time.Second)
+		node := newFakeNode(tip, 1, 0, 0, blockTime)
+		nodes[i] = node
+		tip = node
+	}
+	return nodes
+}
+
+// chainedFakeSkipListNodes returns the specified number of nodes constructed
+// such that each subsequent node points to the previous one to create a
+// chain suitable for testing the skip list functionality.  The first node
+// will point to the passed parent, which can be nil if desired.
+func chainedFakeSkipListNodes(parent *blockNode, numNodes int) []*blockNode {
+	nodes := make([]*blockNode, numNodes)
+	tip := parent
+	for i := 0; i < numNodes; i++ {
+		node := newFakeNode(tip, 1, 0, 0, time.Unix(int64(i), 0))
+		nodes[i] = node
+		tip = node
+	}
+	return nodes
+}
+
+// branchTip is a convenience function to return the tip of a chain of block
+// nodes as returned from chainedFakeNodes or chainedFakeSkipListNodes.
+func branchTip(nodes []*blockNode) *blockNode {
+	return nodes[len(nodes)-1]
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"math/big"
+	"testing"
+)
+
+// TestCalcASERTNextTargetMonotonic ensures the ASERT next-target calculation
+// responds monotonically to the rate at which blocks arrive relative to the
+// ideal spacing: a sustained stream of faster-than-ideal blocks must never
+// decrease required difficulty (i.e. the target must not increase) as more
+// such blocks are observed, and a sustained stream of slower-than-ideal
+// blocks must never increase it.
+func TestCalcASERTNextTargetMonotonic(t *testing.T) {
+	const (
+		targetSpacing = int64(300)   // 5 minutes, matching mainnet spacing.
+		halfLife      = int64(86400) // 1 day.
+		anchorHeight  = int64(0)
+		anchorTime    = int64(1600000000)
+	)
+	powLimit := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 224), big.NewInt(1))
+	anchorTarget := new(big.Int).Rsh(powLimit, 8)
+
+	// A steady stream of blocks arriving twice as fast as the ideal spacing
+	// should cause the target to shrink (difficulty to rise) monotonically.
+	//
+	// calcASERTNextTarget computes heightDiff as lastHeight+1-anchorHeight
+	// (the height of the block being targeted, not lastHeight itself), so
+	// lastTime is derived from that same heightDiff to keep the schedule
+	// consistent with the formula.
+	prevTarget := anchorTarget
+	for height := anchorHeight + 1; height <= anchorHeight+50; height++ {
+		lastHeight := height - 1
+		heightDiff := lastHeight + 1 - anchorHeight
+		lastTime := anchorTime + heightDiff*(targetSpacing/2)
+		target := calcASERTNextTarget(anchorTarget, anchorHeight, anchorTime,
+			lastHeight, lastTime, targetSpacing, halfLife, powLimit)
+		if target.Cmp(prevTarget) > 0 {
+			t.Fatalf("height %d: target increased for a faster-than-ideal "+
+				"block stream -- got %s, previous %s", height, target,
+				prevTarget)
+		}
+		prevTarget = target
+	}
+
+	// A steady stream of blocks arriving twice as slowly as the ideal
+	// spacing should cause the target to grow (difficulty to fall)
+	// monotonically.
+	prevTarget = anchorTarget
+	for height := anchorHeight + 1; height <= anchorHeight+50; height++ {
+		lastHeight := height - 1
+		heightDiff := lastHeight + 1 - anchorHeight
+		lastTime := anchorTime + heightDiff*(targetSpacing*2)
+		target := calcASERTNextTarget(anchorTarget, anchorHeight, anchorTime,
+			lastHeight, lastTime, targetSpacing, halfLife, powLimit)
+		if target.Cmp(prevTarget) < 0 {
+			t.Fatalf("height %d: target decreased for a slower-than-ideal "+
+				"block stream -- got %s, previous %s", height, target,
+				prevTarget)
+		}
+		prevTarget = target
+	}
+}
+
+// TestCalcASERTNextTargetUnchanged ensures that a block stream arriving
+// exactly on the ideal schedule reproduces the anchor target, and that the
+// result is always clamped to powLimit.
+func TestCalcASERTNextTargetUnchanged(t *testing.T) {
+	const (
+		targetSpacing = int64(300)
+		halfLife      = int64(86400)
+		anchorHeight  = int64(1000)
+		anchorTime    = int64(1600000000)
+	)
+	powLimit := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 224), big.NewInt(1))
+	anchorTarget := new(big.Int).Rsh(powLimit, 8)
+
+	// calcASERTNextTarget computes heightDiff as lastHeight+1-anchorHeight
+	// (the height of the block being targeted), so an on-schedule stream
+	// must supply elapsed time for that many blocks, not just lastHeight.
+	lastHeight := anchorHeight + 10
+	heightDiff := lastHeight + 1 - anchorHeight
+	lastTime := anchorTime + heightDiff*targetSpacing
+	target := calcASERTNextTarget(anchorTarget, anchorHeight, anchorTime,
+		lastHeight, lastTime, targetSpacing, halfLife, powLimit)
+	if target.Cmp(anchorTarget) != 0 {
+		t.Fatalf("on-schedule target mismatch -- got %s, want %s", target,
+			anchorTarget)
+	}
+
+	// A target that would otherwise exceed powLimit due to a long run of
+	// slow blocks must be clamped.
+	lastTime = anchorTime + heightDiff*targetSpacing*100000
+	target = calcASERTNextTarget(anchorTarget, anchorHeight, anchorTime,
+		lastHeight, lastTime, targetSpacing, halfLife, powLimit)
+	if target.Cmp(powLimit) != 0 {
+		t.Fatalf("clamped target mismatch -- got %s, want powLimit %s",
+			target, powLimit)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/decred/dcrd/chaincfg/v3"
+)
+
+// TestSplitPoint ensures that blockNode.SplitPoint and the BlockChain.FindFork
+// wrapper around it correctly locate the most recent common ancestor for
+// nodes across the multi-branch fixture also used by TestChainTips.
+func TestSplitPoint(t *testing.T) {
+	params := chaincfg.RegNetParams()
+	bc := newFakeChain(params)
+	genesis := bc.bestChain.NodeByHeight(0)
+
+	// Construct the first five branches of the synthetic structure used by
+	// TestChainTips.
+	// 0 -> 1 -> 2  -> 3  -> 4
+	//  |    \-> 2a -> 3a -> 4a -> 5a -> 6a -> 7a -> ... -> 26a
+	//  |    |     \-> 3b -> 4b -> 5b
+	//  |    \-> 2c -> 3c -> 4c -> 5c -> 6c -> 7c -> ... -> 26c
+	//  \-> 1d
+	branches := make([][]*blockNode, 5)
+	branches[0] = chainedFakeNodes(genesis, 4)
+	branches[1] = chainedFakeNodes(branches[0][0], 25)
+	branches[2] = chainedFakeNodes(branches[1][0], 3)
+	branches[3] = chainedFakeNodes(branches[0][0], 25)
+	branches[4] = chainedFakeNodes(genesis, 1)
+
+	for _, branch := range branches {
+		for _, node := range branch {
+			bc.index.AddNode(node)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		a      *blockNode
+		b      *blockNode
+		wantFn func() *blockNode
+	}{{
+		name:   "same node",
+		a:      branchTip(branches[1]),
+		b:      branchTip(branches[1]),
+		wantFn: func() *blockNode { return branchTip(branches[1]) },
+	}, {
+		name:   "one is an ancestor of the other",
+		a:      branchTip(branches[2]),
+		b:      branches[1][0],
+		wantFn: func() *blockNode { return branches[1][0] },
+	}, {
+		name:   "siblings off of branch 0",
+		a:      branchTip(branches[1]),
+		b:      branchTip(branches[3]),
+		wantFn: func() *blockNode { return branches[0][0] },
+	}, {
+		name:   "deep descendants of siblings off of branch 0",
+		a:      branchTip(branches[2]),
+		b:      branchTip(branches[3]),
+		wantFn: func() *blockNode { return branches[0][0] },
+	}, {
+		name:   "only common ancestor is genesis",
+		a:      branchTip(branches[1]),
+		b:      branchTip(branches[4]),
+		wantFn: func() *blockNode { return genesis },
+	}}
+
+	for _, test := range tests {
+		want := test.wantFn()
+
+		gotAB := test.a.SplitPoint(test.b)
+		if gotAB != want {
+			t.Errorf("%s: SplitPoint(a, b): mismatched fork -- got %v, want %v",
+				test.name, gotAB, want)
+			continue
+		}
+
+		gotBA := test.b.SplitPoint(test.a)
+		if gotBA != want {
+			t.Errorf("%s: SplitPoint(b, a): mismatched fork -- got %v, want %v",
+				test.name, gotBA, want)
+			continue
+		}
+
+		gotHeader := bc.FindFork(&test.a.hash, &test.b.hash)
+		if gotHeader == nil {
+			t.Errorf("%s: FindFork: unexpected nil result", test.name)
+			continue
+		}
+		if gotHash := gotHeader.BlockHash(); gotHash != want.hash {
+			t.Errorf("%s: FindFork: mismatched fork -- got %s, want %s",
+				test.name, gotHash, want.hash)
+		}
+	}
+
+	// A nil node passed to SplitPoint, and a hash that is not known to the
+	// index passed to FindFork, should each result in a nil fork.
+	unknown := mustParseHash("ff")
+	if got := branchTip(branches[1]).SplitPoint(nil); got != nil {
+		t.Errorf("SplitPoint against nil: unexpected non-nil result: %v", got)
+	}
+	if got := bc.FindFork(&branches[1][0].hash, unknown); got != nil {
+		t.Errorf("FindFork with unknown hash: unexpected non-nil result: %v",
+			got)
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"fmt"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/wire"
+)
+
+// Domain separation prefixes used when hashing Merkle tree leaves and
+// internal nodes so that an internal node can never be replayed as a leaf
+// and vice versa, which is what makes the tree resistant to the classic
+// second-preimage (CVE-2012-2459-style) forgery.
+var (
+	headerBatchLeafPrefix = []byte{0x00}
+	headerBatchNodePrefix = []byte{0x01}
+)
+
+// HeaderBatch represents a contiguous run of headers accompanied by a
+// Merkle proof, rooted at a well-known checkpoint, that each header is
+// genuinely part of the batch.  A verifier checks each header against an
+// independently trusted root with an O(log N) Merkle inclusion proof;
+// ProcessHeaderBatch performs no proof-of-work or other per-header validity
+// checks of its own.
+type HeaderBatch struct {
+	// Headers is the contiguous run of headers the batch vouches for, in
+	// height order.
+	Headers []wire.BlockHeader
+
+	// MerkleRoot is the root of the domain-separated Merkle tree built over
+	// the headers.  A verifier must compare this against an independently
+	// trusted checkpoint root rather than trusting it at face value.
+	MerkleRoot chainhash.Hash
+
+	// Proofs holds the inclusion proof for each corresponding entry in
+	// Headers, ordered bottom-up from each header's sibling to the root.
+	Proofs [][]chainhash.Hash
+}
+
+// headerBatchLeaf returns the Merkle tree leaf hash for the given header.
+// The header's own block hash is used as the leaf's payload since it is
+// already a collision-resistant digest of the full header.
+func headerBatchLeaf(header *wire.BlockHeader) chainhash.Hash {
+	blockHash := header.BlockHash()
+	return chainhash.HashH(append(append([]byte(nil), headerBatchLeafPrefix...),
+		blockHash[:]...))
+}
+
+// headerBatchNode returns the Merkle tree parent hash of the given left and
+// right child hashes.
+func headerBatchNode(left, right chainhash.Hash) chainhash.Hash {
+	data := make([]byte, 0, len(headerBatchNodePrefix)+2*chainhash.HashSize)
+	data = append(data, headerBatchNodePrefix...)
+	data = append(data, left[:]...)
+	data = append(data, right[:]...)
+	return chainhash.HashH(data)
+}
+
+// buildHeaderMerkleTree constructs a complete binary Merkle tree over the
+// provided leaves, padding with an all-zero leaf as necessary, and returns
+// the root along with every level of the tree (leaves first) so that
+// per-leaf inclusion proofs can be extracted.
+func buildHeaderMerkleTree(leaves []chainhash.Hash) (chainhash.Hash, [][]chainhash.Hash) {
+	size := 1
+	for size < len(leaves) {
+		size <<= 1
+	}
+
+	level := make([]chainhash.Hash, size)
+	copy(level, leaves)
+	levels := [][]chainhash.Hash{level}
+
+	for len(level) > 1 {
+		next := make([]chainhash.Hash, len(level)/2)
+		for i := range next {
+			next[i] = headerBatchNode(level[2*i], level[2*i+1])
+		}
+		levels = append(levels, next)
+		level = next
+	}
+
+	return level[0], levels
+}
+
+// merkleProofForIndex extracts the bottom-up inclusion proof for the leaf at
+// the provided index from the levels returned by buildHeaderMerkleTree.
+func merkleProofForIndex(levels [][]chainhash.Hash, index int) []chainhash.Hash {
+	proof := make([]chainhash.Hash, 0, len(levels)-1)
+	for _, level := range levels[:len(levels)-1] {
+		proof = append(proof, level[index^1])
+		index >>= 1
+	}
+	return proof
+}
+
+// verifyMerkleProof reports whether the provided leaf hash, found at index
+// among the tree's leaves, is included in the tree with the given root
+// according to proof.
+func verifyMerkleProof(leaf chainhash.Hash, index int, proof []chainhash.Hash, root chainhash.Hash) bool {
+	h := leaf
+	for _, sibling := range proof {
+		if index&1 == 0 {
+			h = headerBatchNode(h, sibling)
+		} else {
+			h = headerBatchNode(sibling, h)
+		}
+		index >>= 1
+	}
+	return h == root
+}
+
+// NewHeaderBatch builds a HeaderBatch, including its Merkle root and a
+// per-header inclusion proof, from the provided contiguous run of headers.
+func NewHeaderBatch(headers []wire.BlockHeader) (*HeaderBatch, error) {
+	if len(headers) == 0 {
+		return nil, fmt.Errorf("cannot build a header batch from zero headers")
+	}
+
+	leaves := make([]chainhash.Hash, len(headers))
+	for i := range headers {
+		leaves[i] = headerBatchLeaf(&headers[i])
+	}
+
+	root, levels := buildHeaderMerkleTree(leaves)
+	proofs := make([][]chainhash.Hash, len(headers))
+	for i := range headers {
+		proofs[i] = merkleProofForIndex(levels, i)
+	}
+
+	return &HeaderBatch{
+		Headers:    append([]wire.BlockHeader(nil), headers...),
+		MerkleRoot: root,
+		Proofs:     proofs,
+	}, nil
+}
+
+// ProcessHeaderBatch verifies that every header in batch is included under
+// the given, independently trusted checkpoint root and, only once the
+// entire batch has been verified, populates the block index with a
+// header-only node (no block data) for each header that is not already
+// known.  Each header must connect to either an already-known parent or an
+// earlier header in the same batch.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ProcessHeaderBatch(batch *HeaderBatch, checkpointRoot chainhash.Hash) error {
+	if len(batch.Headers) == 0 {
+		return fmt.Errorf("header batch is empty")
+	}
+	if len(batch.Headers) != len(batch.Proofs) {
+		return fmt.Errorf("header batch has %d headers but %d proofs",
+			len(batch.Headers), len(batch.Proofs))
+	}
+	if batch.MerkleRoot != checkpointRoot {
+		return fmt.Errorf("header batch root %s does not match checkpoint "+
+			"root %s", batch.MerkleRoot, checkpointRoot)
+	}
+
+	for i := range batch.Headers {
+		leaf := headerBatchLeaf(&batch.Headers[i])
+		if !verifyMerkleProof(leaf, i, batch.Proofs[i], checkpointRoot) {
+			hash := batch.Headers[i].BlockHash()
+			return fmt.Errorf("header %d (%s) failed Merkle proof "+
+				"verification against checkpoint root %s", i, hash,
+				checkpointRoot)
+		}
+	}
+
+	for i := range batch.Headers {
+		header := &batch.Headers[i]
+		hash := header.BlockHash()
+		if b.index.LookupNode(&hash) != nil {
+			continue
+		}
+
+		parent := b.index.LookupNode(&header.PrevBlock)
+		if parent == nil {
+			return fmt.Errorf("header %d (%s) does not connect to a known "+
+				"parent %s", i, hash, header.PrevBlock)
+		}
+
+		b.index.AddNode(newBlockNode(header, parent))
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/decred/dcrd/chaincfg/v3"
+	"github.com/decred/dcrd/wire"
+)
+
+// TestProcessHeaderBatch ensures a header batch built from a contiguous run
+// of headers verifies and connects cleanly against the checkpoint root it
+// was built with, and that tampering with any single header after the fact
+// is detected and rejected.
+func TestProcessHeaderBatch(t *testing.T) {
+	params := chaincfg.RegNetParams()
+	bc := newFakeChain(params)
+	genesis := bc.bestChain.Tip()
+
+	chain := chainedFakeNodes(genesis, 20)
+	headers := make([]wire.BlockHeader, len(chain))
+	for i, node := range chain {
+		headers[i] = node.Header()
+	}
+
+	batch, err := NewHeaderBatch(headers)
+	if err != nil {
+		t.Fatalf("NewHeaderBatch: unexpected error: %v", err)
+	}
+
+	if err := bc.ProcessHeaderBatch(batch, batch.MerkleRoot); err != nil {
+		t.Fatalf("ProcessHeaderBatch: unexpected error: %v", err)
+	}
+	for _, node := range chain {
+		if bc.index.LookupNode(&node.hash) == nil {
+			t.Fatalf("ProcessHeaderBatch: header %s was not added to the "+
+				"index", node.hash)
+		}
+	}
+
+	// Reprocessing the same, untampered batch must be a harmless no-op.
+	if err := bc.ProcessHeaderBatch(batch, batch.MerkleRoot); err != nil {
+		t.Fatalf("ProcessHeaderBatch: unexpected error on reprocess: %v", err)
+	}
+
+	// Tampering with a single header without updating its proof must be
+	// detected and rejected before anything is added to the index.
+	bc2 := newFakeChain(params)
+	tampered, err := NewHeaderBatch(headers)
+	if err != nil {
+		t.Fatalf("NewHeaderBatch: unexpected error: %v", err)
+	}
+	tampered.Headers[10].Nonce++
+
+	err = bc2.ProcessHeaderBatch(tampered, tampered.MerkleRoot)
+	if err == nil {
+		t.Fatal("ProcessHeaderBatch: expected error for tampered header, " +
+			"got nil")
+	}
+	for i := range chain {
+		hash := headers[i].BlockHash()
+		if bc2.index.LookupNode(&hash) != nil {
+			t.Fatalf("ProcessHeaderBatch: header %d was added to the index "+
+				"despite the batch being rejected", i)
+		}
+	}
+}
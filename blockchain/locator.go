@@ -0,0 +1,109 @@
+// Copyright (c) 2013-2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/wire"
+)
+
+// BlockLocator is used to help locate a specific block.  The algorithm for
+// building the block locator is to add the hashes in reverse order until
+// the genesis block is reached using the iteratively decreasing, then
+// exponentially decreasing height strategy implemented in
+// BlockLocatorFromNode.
+type BlockLocator []*chainhash.Hash
+
+// BlockLocatorFromNode returns a block locator rooted at the passed node.
+// The locator is built from the node's own hash, the eleven most recent
+// ancestors of the node, and then successively doubling the step between
+// included ancestors all the way back to genesis, using the node's skip
+// list to make each step an O(log n) lookup.
+//
+// Unlike walking back from the best chain tip, this can be rooted at any
+// node in the index, including the tip of a side branch, which makes it
+// usable for side-chain reconciliation and RPC-driven audits in addition to
+// ordinary header-first sync.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) BlockLocatorFromNode(node *blockNode) BlockLocator {
+	var locator BlockLocator
+	if node == nil {
+		return locator
+	}
+
+	step := int64(1)
+	for node != nil {
+		hash := node.hash
+		locator = append(locator, &hash)
+
+		if node.height == 0 {
+			break
+		}
+
+		height := node.height - step
+		if height < 0 {
+			height = 0
+		}
+		node = node.Ancestor(height)
+
+		if len(locator) > 10 {
+			step *= 2
+		}
+	}
+
+	return locator
+}
+
+// locateStartNode returns the node associated with the first hash in the
+// locator that is known and part of the current best chain, or the genesis
+// node when none of the locator's hashes qualify.
+func (b *BlockChain) locateStartNode(locator BlockLocator) *blockNode {
+	tip := b.bestChain.Tip()
+	for _, hash := range locator {
+		node := b.index.LookupNode(hash)
+		if node == nil {
+			continue
+		}
+		if tip != nil && node.height <= tip.height && tip.Ancestor(node.height) == node {
+			return node
+		}
+	}
+
+	return b.bestChain.NodeByHeight(0)
+}
+
+// LocateHeadersFromLocator returns the headers of the best chain starting
+// immediately after the first hash in locator that is both known and part
+// of the best chain, continuing until either stopHash is reached, maxHeaders
+// headers have been collected, or the best chain tip is reached.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) LocateHeadersFromLocator(locator BlockLocator, stopHash *chainhash.Hash, maxHeaders int) []wire.BlockHeader {
+	startNode := b.locateStartNode(locator)
+	if startNode == nil {
+		return nil
+	}
+
+	tip := b.bestChain.Tip()
+	if tip == nil {
+		return nil
+	}
+
+	headers := make([]wire.BlockHeader, 0, maxHeaders)
+	for height := startNode.height + 1; height <= tip.height && len(headers) < maxHeaders; height++ {
+		node := tip.Ancestor(height)
+		if node == nil {
+			break
+		}
+
+		headers = append(headers, node.Header())
+		if stopHash != nil && node.hash == *stopHash {
+			break
+		}
+	}
+
+	return headers
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/decred/dcrd/chaincfg/v3"
+)
+
+// checkLocatorSpacing verifies that locator, built from tip, has the
+// exponential every-block-then-doubling spacing invariant: the first entry
+// is the tip itself, the following entries decrease by one height each
+// until twelve entries are present, the step between entries doubles from
+// there on, and the final entry is always genesis.
+func checkLocatorSpacing(t *testing.T, bc *BlockChain, tip *blockNode, locator BlockLocator) {
+	t.Helper()
+
+	if len(locator) == 0 {
+		t.Fatal("locator is unexpectedly empty")
+	}
+
+	heights := make([]int64, len(locator))
+	for i, hash := range locator {
+		node := bc.index.LookupNode(hash)
+		if node == nil {
+			t.Fatalf("locator entry %d (%s) is not a known block", i, hash)
+		}
+		heights[i] = node.height
+	}
+
+	if heights[0] != tip.height {
+		t.Fatalf("first locator entry has height %d, want tip height %d",
+			heights[0], tip.height)
+	}
+	if last := heights[len(heights)-1]; last != 0 {
+		t.Fatalf("last locator entry has height %d, want genesis height 0",
+			last)
+	}
+
+	// BlockLocatorFromNode only doubles the step once the locator holds
+	// more than 10 entries, and it computes the height of the next entry
+	// before that check, so entry 11 is still one below entry 10 and the
+	// doubled step is first used for entry 12.
+	step := int64(1)
+	for i := 1; i < len(heights); i++ {
+		want := heights[i-1] - step
+		if want < 0 {
+			want = 0
+		}
+		if heights[i] != want {
+			t.Fatalf("locator entry %d has height %d, want %d", i,
+				heights[i], want)
+		}
+		if i >= 11 {
+			step *= 2
+		}
+		if want == 0 && i != len(heights)-1 {
+			t.Fatalf("locator continued past genesis at entry %d", i)
+		}
+	}
+}
+
+// TestBlockLocatorFromNode ensures block locators built from several branch
+// tips in a variant of the TestChainTips fixture (with the 2c branch
+// extended to 60 blocks) -- not only the best chain tip -- respect the
+// exponential spacing invariant.
+func TestBlockLocatorFromNode(t *testing.T) {
+	params := chaincfg.RegNetParams()
+	bc := newFakeChain(params)
+	genesis := bc.bestChain.NodeByHeight(0)
+
+	branches := make([][]*blockNode, 5)
+	branches[0] = chainedFakeNodes(genesis, 4)
+	branches[1] = chainedFakeNodes(branches[0][0], 25)
+	branches[2] = chainedFakeNodes(branches[1][0], 3)
+	branches[3] = chainedFakeNodes(branches[0][0], 60)
+	branches[4] = chainedFakeNodes(genesis, 1)
+
+	for _, branch := range branches {
+		for _, node := range branch {
+			bc.index.AddNode(node)
+		}
+	}
+
+	tips := []*blockNode{
+		genesis,
+		branchTip(branches[1]),
+		branchTip(branches[2]),
+		branchTip(branches[3]),
+		branchTip(branches[4]),
+	}
+	for _, tip := range tips {
+		locator := bc.BlockLocatorFromNode(tip)
+		checkLocatorSpacing(t, bc, tip, locator)
+	}
+
+	if got := bc.BlockLocatorFromNode(nil); len(got) != 0 {
+		t.Fatalf("BlockLocatorFromNode(nil): expected empty locator, got %v",
+			got)
+	}
+}
+
+// TestLocateHeadersFromLocator ensures headers are located starting from the
+// point in the locator that intersects the best chain.
+func TestLocateHeadersFromLocator(t *testing.T) {
+	params := chaincfg.RegNetParams()
+	bc := newFakeChain(params)
+	genesis := bc.bestChain.Tip()
+
+	mainChain := chainedFakeNodes(genesis, 30)
+	for _, node := range mainChain {
+		bc.index.AddNode(node)
+		bc.bestChain.SetTip(node)
+		bc.index.setTip(node)
+	}
+
+	locator := bc.BlockLocatorFromNode(mainChain[9])
+	headers := bc.LocateHeadersFromLocator(locator, nil, 100)
+
+	wantCount := len(mainChain) - 10
+	if len(headers) != wantCount {
+		t.Fatalf("got %d headers, want %d", len(headers), wantCount)
+	}
+	for i, header := range headers {
+		wantHash := mainChain[10+i].hash
+		if got := header.BlockHash(); got != wantHash {
+			t.Fatalf("header %d: got hash %s, want %s", i, got, wantHash)
+		}
+	}
+
+	// Limiting maxHeaders truncates the result.
+	headers = bc.LocateHeadersFromLocator(locator, nil, 5)
+	if len(headers) != 5 {
+		t.Fatalf("got %d headers, want 5", len(headers))
+	}
+
+	// A stop hash truncates the result at (and including) that header.
+	stopHash := mainChain[15].hash
+	headers = bc.LocateHeadersFromLocator(locator, &stopHash, 100)
+	if len(headers) != 6 {
+		t.Fatalf("got %d headers, want 6", len(headers))
+	}
+	if got := headers[len(headers)-1].BlockHash(); got != stopHash {
+		t.Fatalf("last header hash %s, want stop hash %s", got, stopHash)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2020 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/chaincfg/v3"
+)
+
+// TestSuitableBlock ensures that SuitableBlock returns the median-by-
+// timestamp of a node and its two most recent ancestors, falling back
+// appropriately near the beginning of the chain where fewer than two
+// ancestors exist.
+func TestSuitableBlock(t *testing.T) {
+	tests := []struct {
+		name       string
+		timestamps []int64 // in chain order, oldest first
+		wantIndex  int     // index into timestamps of the expected result
+	}{{
+		name:       "single block (genesis only)",
+		timestamps: []int64{100},
+		wantIndex:  0,
+	}, {
+		name:       "two blocks",
+		timestamps: []int64{100, 200},
+		wantIndex:  0,
+	}, {
+		name:       "three blocks, in order",
+		timestamps: []int64{100, 200, 300},
+		wantIndex:  1,
+	}, {
+		name:       "three blocks, middle ancestor is latest",
+		timestamps: []int64{100, 300, 200},
+		wantIndex:  2,
+	}, {
+		name:       "three blocks, tip is earliest",
+		timestamps: []int64{300, 200, 100},
+		wantIndex:  1,
+	}, {
+		name:       "three blocks, grandparent is latest",
+		timestamps: []int64{300, 100, 200},
+		wantIndex:  2,
+	}}
+
+	params := chaincfg.RegNetParams()
+	for _, test := range tests {
+		bc := newFakeChain(params)
+		node := bc.bestChain.Tip()
+		node.timestamp = test.timestamps[0]
+
+		nodes := []*blockNode{node}
+		for _, ts := range test.timestamps[1:] {
+			node = newFakeNode(node, 0, 0, 0, time.Unix(ts, 0))
+			nodes = append(nodes, node)
+		}
+
+		got := nodes[len(nodes)-1].SuitableBlock()
+		want := nodes[test.wantIndex]
+		if got != want {
+			t.Errorf("%s: mismatched result -- got timestamp %d, want %d",
+				test.name, got.timestamp, want.timestamp)
+		}
+	}
+}